@@ -0,0 +1,263 @@
+package tadoauth
+
+// accountSession owns everything needed to authenticate, refresh and
+// gather metrics for a single Tado account: its own AuthFlow, TokenStore,
+// refresh-rotation state and tadoclient.Client. Tadoauth coordinates one
+// or more of these so a single [[inputs.tadoauth]] plugin instance can
+// manage several Tado accounts (e.g. landlord + tenant, or several
+// homes).
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/mhabing/tadoauth/plugins/inputs/tadoauth/tadoclient"
+)
+
+// accountConfig is the fully-resolved configuration for one account: the
+// coordinator's top-level settings with any [[inputs.tadoauth.account]]
+// overrides applied.
+type accountConfig struct {
+	Name          string
+	URL           string
+	AuthFlowName  string
+	ClientID      string
+	ClientSecret  string
+	Username      string
+	Password      string
+	TokenPath     string
+	DeviceAuthURL string
+	AuthURL       string
+	TokenStore    string
+	APIURL        string
+	HomeIDs       []int
+}
+
+// accountSession is the runtime state for one account. Its exported
+// behavior (AccessToken, gather) is safe for concurrent use: AccessToken
+// is read from Gather/TokenFor while background refreshes it from its own
+// goroutine.
+type accountSession struct {
+	cfg accountConfig
+
+	flow       AuthFlow
+	tokenStore TokenStore
+	client     *tadoclient.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu           sync.RWMutex
+	accessToken  string
+	refreshToken string
+	tokenID      string
+	nonce        uint64
+	expiresIn    time.Duration
+}
+
+// tokenSource adapts accountSession to tadoclient.TokenSource.
+type tokenSource struct{ s *accountSession }
+
+func (t tokenSource) AccessToken() string { return t.s.AccessToken() }
+
+// newAccountSession builds the AuthFlow, TokenStore and tadoclient.Client
+// for cfg, but does not yet authenticate.
+func newAccountSession(cfg accountConfig) (*accountSession, error) {
+	s := &accountSession{cfg: cfg}
+
+	flow, err := buildAuthFlow(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.flow = flow
+
+	store, err := buildTokenStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.tokenStore = store
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.client = tadoclient.New(cfg.APIURL, tokenSource{s})
+	return s, nil
+}
+
+// buildAuthFlow selects the AuthFlow implementation named by
+// cfg.AuthFlowName, defaulting to the legacy password grant for
+// backwards compatibility.
+func buildAuthFlow(cfg accountConfig) (AuthFlow, error) {
+	switch cfg.AuthFlowName {
+	case "", "password":
+		return &PasswordFlow{
+			TokenURL:     cfg.URL,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			Scope:        "home.user",
+		}, nil
+	case "device":
+		return &DeviceFlow{
+			DeviceAuthURL: cfg.DeviceAuthURL,
+			TokenURL:      cfg.URL,
+			ClientID:      cfg.ClientID,
+			Scope:         "home.user",
+		}, nil
+	case "pkce":
+		return &PKCEFlow{
+			AuthURL:  cfg.AuthURL,
+			TokenURL: cfg.URL,
+			ClientID: cfg.ClientID,
+			Scope:    "home.user",
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth_flow %q, expected \"password\", \"device\" or \"pkce\"", cfg.AuthFlowName)
+	}
+}
+
+// buildTokenStore selects the TokenStore implementation named by
+// cfg.TokenStore, defaulting to a file at cfg.TokenPath.
+func buildTokenStore(cfg accountConfig) (TokenStore, error) {
+	switch cfg.TokenStore {
+	case "", "file":
+		return &FileTokenStore{Path: cfg.TokenPath}, nil
+	case "memory":
+		return &MemoryTokenStore{}, nil
+	case "keychain":
+		return &KeychainTokenStore{Service: "tadoauth", Account: cfg.Name}, nil
+	default:
+		return nil, fmt.Errorf("unknown token_store %q, expected \"file\", \"memory\" or \"keychain\"", cfg.TokenStore)
+	}
+}
+
+// init resumes this account's session from a previously persisted token,
+// refreshing it for a current access token, and only falls back to a full
+// (interactive, for device/pkce) Authenticate when there's no stored
+// token or resuming it fails. Without this, every restart would force a
+// fresh login instead of quietly continuing from the refresh token a
+// prior run left behind.
+func (s *accountSession) init() error {
+	stored, err := s.tokenStore.Load()
+	if err != nil {
+		if errors.Is(err, ErrNoToken) {
+			return s.auth()
+		}
+		return fmt.Errorf("account %s: could not load token store: %w", s.cfg.Name, err)
+	}
+
+	s.mu.Lock()
+	s.tokenID = stored.TokenID
+	s.mu.Unlock()
+
+	if err := s.rotateRefresh(stored.RefreshToken, stored.Nonce+1); err != nil {
+		return s.auth()
+	}
+	return nil
+}
+
+// start launches the background refresh loop for this account.
+func (s *accountSession) start(acc telegraf.Accumulator) {
+	go s.background(s.ctx, acc)
+}
+
+// stop shuts down the background refresh loop started by start.
+func (s *accountSession) stop() {
+	s.cancel()
+}
+
+// AccessToken returns the current access token. Safe for concurrent use.
+func (s *accountSession) AccessToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.accessToken
+}
+
+// reauth rotates the refresh token. Per RFC 6819 §5.2.2.3, the refresh
+// token returned by every refresh is itself single-use: before using the
+// one we have in memory we confirm the token store still agrees it's the
+// current one. A mismatch means the token was already rotated elsewhere
+// (a leaked/stale refresh token being reused), so we refuse to continue
+// and force a full re-authentication instead.
+func (s *accountSession) reauth() error {
+	stored, err := s.tokenStore.Load()
+	if err != nil {
+		return fmt.Errorf("account %s: could not load token store: %w", s.cfg.Name, err)
+	}
+
+	s.mu.RLock()
+	tokenID, nonce := s.tokenID, s.nonce
+	s.mu.RUnlock()
+
+	if stored.TokenID != tokenID || stored.Nonce != nonce {
+		if err := s.auth(); err != nil {
+			return fmt.Errorf("account %s: refresh token reuse detected, re-authentication failed: %w", s.cfg.Name, err)
+		}
+		return nil
+	}
+
+	return s.rotateRefresh(stored.RefreshToken, nonce+1)
+}
+
+// rotateRefresh exchanges refreshToken for a new token pair via the
+// configured AuthFlow, records the rotation at nextNonce, and persists
+// the result. The exchange runs under s.ctx so a refresh stuck against an
+// unresponsive server is aborted by stop() rather than left to block.
+func (s *accountSession) rotateRefresh(refreshToken string, nextNonce uint64) error {
+	tok, err := s.flow.Refresh(s.ctx, refreshToken)
+	if err != nil {
+		return fmt.Errorf("account %s: %w", s.cfg.Name, err)
+	}
+
+	s.mu.Lock()
+	s.accessToken = tok.AccessToken
+	s.refreshToken = tok.RefreshToken
+	s.nonce = nextNonce
+	s.expiresIn = time.Duration(tok.ExpiresIn) * time.Second
+	s.mu.Unlock()
+
+	return s.persist()
+}
+
+// auth authenticates via the configured AuthFlow, then starts a fresh
+// rotation chain in the token store. Like rotateRefresh, this runs under
+// s.ctx so stop() can abort an interactive login still in progress.
+func (s *accountSession) auth() error {
+	tok, err := s.flow.Authenticate(s.ctx)
+	if err != nil {
+		return fmt.Errorf("account %s: %w", s.cfg.Name, err)
+	}
+
+	id, err := newTokenID()
+	if err != nil {
+		return fmt.Errorf("account %s: could not start token rotation chain: %w", s.cfg.Name, err)
+	}
+
+	s.mu.Lock()
+	s.accessToken = tok.AccessToken
+	s.refreshToken = tok.RefreshToken
+	s.tokenID = id
+	s.nonce = 0
+	s.expiresIn = time.Duration(tok.ExpiresIn) * time.Second
+	s.mu.Unlock()
+
+	return s.persist()
+}
+
+// persist saves the current token chain state, keyed by TokenID and
+// Nonce, to the configured TokenStore.
+func (s *accountSession) persist() error {
+	s.mu.RLock()
+	rec := &TokenRecord{
+		TokenID:      s.tokenID,
+		Nonce:        s.nonce,
+		AccessToken:  s.accessToken,
+		RefreshToken: s.refreshToken,
+		LastUsed:     time.Now(),
+	}
+	s.mu.RUnlock()
+	return s.tokenStore.Save(rec)
+}
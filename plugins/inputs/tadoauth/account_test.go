@@ -0,0 +1,286 @@
+package tadoauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+// fakeTadoServer fakes just enough of Tado's OAuth token endpoint and
+// REST API for the multi-account tests below: each username gets its own
+// home, and access tokens embed the username so requests can be routed
+// back to it without a real session store.
+type fakeTadoServer struct {
+	mu           sync.Mutex
+	generation   map[string]int
+	validRefresh map[string]string
+	homeIDs      map[string]int
+}
+
+func newFakeTadoServer() *http.ServeMux {
+	f := &fakeTadoServer{
+		generation:   map[string]int{},
+		validRefresh: map[string]string{},
+		homeIDs:      map[string]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", f.handleToken)
+	mux.HandleFunc("/api/v2/me", f.handleMe)
+	mux.HandleFunc("/api/v2/homes/", f.handleHome)
+	return mux
+}
+
+func (f *fakeTadoServer) accessToken(username string, nonce int) string {
+	return fmt.Sprintf("access-%s-%d", username, nonce)
+}
+
+func (f *fakeTadoServer) refreshToken(username string, nonce int) string {
+	return fmt.Sprintf("refresh-%s-%d", username, nonce)
+}
+
+func (f *fakeTadoServer) homeID(username string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.homeIDs[username]; ok {
+		return id
+	}
+	id := len(f.homeIDs) + 1
+	f.homeIDs[username] = id
+	return id
+}
+
+func (f *fakeTadoServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var username string
+
+	switch r.FormValue("grant_type") {
+	case "password":
+		username = r.FormValue("username")
+	case "refresh_token":
+		submitted := r.FormValue("refresh_token")
+		parts := strings.Split(submitted, "-")
+		if len(parts) != 3 {
+			json.NewEncoder(w).Encode(SrvError{Error: "invalid_grant", Description: "malformed refresh token"})
+			return
+		}
+		username = parts[1]
+
+		f.mu.Lock()
+		valid := f.validRefresh[username]
+		f.mu.Unlock()
+		if submitted != valid {
+			json.NewEncoder(w).Encode(SrvError{Error: "invalid_grant", Description: "refresh token already used"})
+			return
+		}
+	default:
+		json.NewEncoder(w).Encode(SrvError{Error: "unsupported_grant_type"})
+		return
+	}
+
+	f.mu.Lock()
+	gen := f.generation[username]
+	f.generation[username] = gen + 1
+	refreshToken := f.refreshToken(username, gen)
+	f.validRefresh[username] = refreshToken
+	f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  f.accessToken(username, gen),
+		RefreshToken: refreshToken,
+		TokenType:    "bearer",
+		ExpiresIn:    3600,
+	})
+}
+
+// usernameFor extracts the username embedded in an access-<user>-<nonce>
+// bearer token.
+func (f *fakeTadoServer) usernameFor(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer access-"
+	if !strings.HasPrefix(auth, prefix) {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(auth, prefix), "-")
+	if len(parts) != 2 {
+		return "", false
+	}
+	return parts[0], true
+}
+
+func (f *fakeTadoServer) handleMe(w http.ResponseWriter, r *http.Request) {
+	username, ok := f.usernameFor(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":  username,
+		"homes": []map[string]interface{}{{"id": f.homeID(username), "name": username + "'s home"}},
+	})
+}
+
+// handleHome serves both the zones list and zone state endpoints with a
+// single fixed zone, since the rotation/TokenFor tests don't care about
+// the metric values themselves.
+func (f *fakeTadoServer) handleHome(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/zones") {
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"id": 1, "name": "Living Room"}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"setting":            map[string]interface{}{"power": "ON", "temperature": map[string]float64{"celsius": 20}},
+		"sensorDataPoints":   map[string]interface{}{"insideTemperature": map[string]float64{"celsius": 19.5}, "humidity": map[string]float64{"percentage": 45}},
+		"activityDataPoints": map[string]interface{}{"heatingPower": map[string]float64{"percentage": 30}},
+	})
+}
+
+func TestTadoauthMultiAccountTokenFor(t *testing.T) {
+	srv := httptest.NewServer(newFakeTadoServer())
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		accounts []AccountConfig
+	}{
+		{
+			name: "two accounts",
+			accounts: []AccountConfig{
+				{Name: "landlord", Username: "landlord", Password: "pw", TokenPath: filepath.Join(dir, "landlord.dat")},
+				{Name: "tenant", Username: "tenant", Password: "pw", TokenPath: filepath.Join(dir, "tenant.dat")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Tadoauth{
+				URL:          srv.URL + "/oauth/token",
+				AuthFlowName: "password",
+				APIURL:       srv.URL,
+				Accounts:     tt.accounts,
+			}
+
+			if err := c.Init(); err != nil {
+				t.Fatalf("Init() = %v", err)
+			}
+
+			var acc testutil.Accumulator
+			if err := c.Gather(&acc); err != nil {
+				t.Fatalf("Gather() = %v", err)
+			}
+			if len(acc.Errors) != 0 {
+				t.Fatalf("Gather() produced errors: %v", acc.Errors)
+			}
+
+			var wg sync.WaitGroup
+			for homeID := 1; homeID <= len(tt.accounts); homeID++ {
+				homeID := homeID
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := c.TokenFor(homeID); err != nil {
+						t.Errorf("TokenFor(%d) = %v", homeID, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if _, err := c.TokenFor(len(tt.accounts) + 1); err == nil {
+				t.Fatalf("TokenFor(unknown home) expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestTadoauthRefreshTokenReuseForcesReauth(t *testing.T) {
+	srv := httptest.NewServer(newFakeTadoServer())
+	defer srv.Close()
+
+	dir := t.TempDir()
+	c := &Tadoauth{
+		URL:          srv.URL + "/oauth/token",
+		AuthFlowName: "password",
+		APIURL:       srv.URL,
+		Username:     "single",
+		Password:     "pw",
+		TokenPath:    filepath.Join(dir, "single.dat"),
+	}
+
+	if err := c.Init(); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+
+	session := c.sessions[0]
+	firstToken := session.AccessToken()
+
+	// Simulate the stored refresh token having already been rotated by
+	// another process: the in-memory nonce is now stale.
+	stored, err := session.tokenStore.Load()
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	stored.Nonce++
+	if err := session.tokenStore.Save(stored); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	if err := session.reauth(); err != nil {
+		t.Fatalf("reauth() = %v", err)
+	}
+
+	if session.AccessToken() == firstToken {
+		t.Fatalf("expected reuse detection to force a fresh access token")
+	}
+}
+
+func TestTadoauthInitResumesFromPersistedToken(t *testing.T) {
+	srv := httptest.NewServer(newFakeTadoServer())
+	defer srv.Close()
+
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "single.dat")
+
+	first := &Tadoauth{
+		URL:          srv.URL + "/oauth/token",
+		AuthFlowName: "password",
+		APIURL:       srv.URL,
+		Username:     "single",
+		Password:     "pw",
+		TokenPath:    tokenPath,
+	}
+	if err := first.Init(); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+
+	// A second instance, as if telegraf had just restarted, pointed at the
+	// same token store but deliberately configured with a device-flow
+	// authorization endpoint that doesn't exist. If Init resumed correctly
+	// from the persisted refresh token it never needs to reach that URL;
+	// if it instead fell back to a full (interactive) Authenticate, this
+	// would fail.
+	second := &Tadoauth{
+		URL:           srv.URL + "/oauth/token",
+		AuthFlowName:  "device",
+		DeviceAuthURL: "http://127.0.0.1:0/unreachable",
+		APIURL:        srv.URL,
+		Username:      "single",
+		TokenPath:     tokenPath,
+	}
+	if err := second.Init(); err != nil {
+		t.Fatalf("Init() did not resume from the persisted token: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package tadoauth
+
+// AuthFlow implementations perform the initial login against the Tado
+// authorization server and exchange refresh tokens for new access tokens.
+// Selecting a flow lets the operator avoid baking a client secret into the
+// binary and move off the deprecated password (ROPC) grant at their own
+// pace.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TokenResponse is the token pair (and metadata) returned by any of the
+// Tado OAuth2 grants.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// AuthFlow is the strategy interface for obtaining and refreshing Tado
+// OAuth2 tokens. Each account session holds one AuthFlow, selected via
+// the `auth_flow` config option.
+type AuthFlow interface {
+	// Authenticate performs whatever interaction the flow requires (posting
+	// the configured credentials, printing a verification URL, opening a
+	// loopback listener, ...) and returns the resulting token pair.
+	Authenticate(ctx context.Context) (*TokenResponse, error)
+	// Refresh exchanges a refresh token for a new token pair.
+	Refresh(ctx context.Context, refreshToken string) (*TokenResponse, error)
+}
+
+// OAuthError wraps the error Tado's authorization server reports in a
+// token endpoint response body, e.g. "authorization_pending" while a
+// device flow is awaiting user login.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("tado returned error: %s (%s)", e.Code, e.Description)
+}
+
+// doPostForm POSTs url-encoded form values to targetURL, honoring ctx so a
+// refresh in flight when the caller's context is cancelled (e.g. Stop())
+// is aborted rather than left to block until the HTTP call returns.
+func doPostForm(ctx context.Context, targetURL string, values url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to tado: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("server response error %s: %w", targetURL, err)
+	}
+	return b, nil
+}
+
+// postForm POSTs url-encoded form values to tokenURL and decodes the
+// response into a TokenResponse, surfacing any error the server reports
+// in its body as an *OAuthError.
+func postForm(ctx context.Context, tokenURL string, values url.Values) (*TokenResponse, error) {
+	var srvErr SrvError
+
+	b, err := doPostForm(ctx, tokenURL, values)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &srvErr); err == nil && srvErr.Error != "" {
+		return nil, &OAuthError{Code: srvErr.Error, Description: srvErr.Description}
+	}
+
+	var tok TokenResponse
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return nil, fmt.Errorf("tado returned malformed response: %w", err)
+	}
+	return &tok, nil
+}
+
+// PasswordFlow implements the legacy ROPC ("password") grant. Tado is
+// deprecating this grant; prefer DeviceFlow or PKCEFlow for new setups.
+type PasswordFlow struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	Scope        string
+}
+
+func (f *PasswordFlow) Authenticate(ctx context.Context) (*TokenResponse, error) {
+	return postForm(ctx, f.TokenURL, url.Values{
+		"client_id":     {f.ClientID},
+		"client_secret": {f.ClientSecret},
+		"grant_type":    {"password"},
+		"scope":         {f.Scope},
+		"username":      {f.Username},
+		"password":      {f.Password},
+	})
+}
+
+func (f *PasswordFlow) Refresh(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	return postForm(ctx, f.TokenURL, url.Values{
+		"client_id":     {f.ClientID},
+		"client_secret": {f.ClientSecret},
+		"grant_type":    {"refresh_token"},
+		"scope":         {f.Scope},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// deviceCodeResponse is the response to an RFC 8628 device authorization
+// request.
+type deviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceFlow implements the OAuth2 device authorization grant (RFC 8628):
+// the user is shown a verification URL to complete on another device while
+// this process polls the token endpoint until they do.
+type DeviceFlow struct {
+	DeviceAuthURL string
+	TokenURL      string
+	ClientID      string
+	Scope         string
+
+	// Prompt is called with the verification URL to show the user. Defaults
+	// to printing it to stdout.
+	Prompt func(verificationURIComplete string)
+}
+
+func (f *DeviceFlow) Authenticate(ctx context.Context) (*TokenResponse, error) {
+	b, err := doPostForm(ctx, f.DeviceAuthURL, url.Values{
+		"client_id": {f.ClientID},
+		"scope":     {f.Scope},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not start device authorization: %w", err)
+	}
+
+	var dc deviceCodeResponse
+	if err := json.Unmarshal(b, &dc); err != nil {
+		return nil, fmt.Errorf("tado returned malformed device authorization response: %w", err)
+	}
+
+	prompt := f.Prompt
+	if prompt == nil {
+		prompt = func(uri string) {
+			fmt.Printf("To authenticate with Tado, open: %s\n", uri)
+		}
+	}
+	prompt(dc.VerificationURIComplete)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+	for {
+		if dc.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("device code expired before user completed login")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		tok, err := postForm(ctx, f.TokenURL, url.Values{
+			"client_id":   {f.ClientID},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {dc.DeviceCode},
+		})
+		if err == nil {
+			return tok, nil
+		}
+
+		switch {
+		case isOAuthError(err, "authorization_pending"):
+			continue
+		case isOAuthError(err, "slow_down"):
+			interval += 5 * time.Second
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
+
+func (f *DeviceFlow) Refresh(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	return postForm(ctx, f.TokenURL, url.Values{
+		"client_id":     {f.ClientID},
+		"grant_type":    {"refresh_token"},
+		"scope":         {f.Scope},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// isOAuthError reports whether err is an *OAuthError with the given RFC
+// 8628 error code.
+func isOAuthError(err error, code string) bool {
+	var oauthErr *OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == code
+}
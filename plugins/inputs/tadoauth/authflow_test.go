@@ -0,0 +1,260 @@
+package tadoauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDeviceServer fakes the RFC 8628 endpoints DeviceFlow.Authenticate
+// drives: /device_authorize and /token. slowDowns and pending control how
+// many times the token endpoint reports slow_down and then
+// authorization_pending before finally succeeding.
+type fakeDeviceServer struct {
+	mu        sync.Mutex
+	polls     int
+	pending   int
+	slowDowns int
+}
+
+func newFakeDeviceServer(pending, slowDowns int) *http.ServeMux {
+	f := &fakeDeviceServer{pending: pending, slowDowns: slowDowns}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device_authorize", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(deviceCodeResponse{
+			DeviceCode:              "dc-1",
+			UserCode:                "ABCD-EFGH",
+			VerificationURIComplete: "https://example.com/device?user_code=ABCD-EFGH",
+			ExpiresIn:               60,
+			Interval:                1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.polls++
+
+		if f.slowDowns > 0 {
+			f.slowDowns--
+			json.NewEncoder(w).Encode(SrvError{Error: "slow_down"})
+			return
+		}
+		if f.pending > 0 {
+			f.pending--
+			json.NewEncoder(w).Encode(SrvError{Error: "authorization_pending"})
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "access-1", RefreshToken: "refresh-1", ExpiresIn: 3600})
+	})
+	return mux
+}
+
+func TestDeviceFlowAuthenticatePollsUntilApproved(t *testing.T) {
+	srv := httptest.NewServer(newFakeDeviceServer(1, 0))
+	defer srv.Close()
+
+	var prompted string
+	f := &DeviceFlow{
+		DeviceAuthURL: srv.URL + "/device_authorize",
+		TokenURL:      srv.URL + "/token",
+		ClientID:      "client-1",
+		Prompt:        func(uri string) { prompted = uri },
+	}
+
+	tok, err := f.Authenticate(context.Background())
+	if err != nil {
+		t.Fatalf("Authenticate() = %v", err)
+	}
+	if tok.AccessToken != "access-1" {
+		t.Fatalf("AccessToken = %q, want %q", tok.AccessToken, "access-1")
+	}
+	if prompted == "" {
+		t.Fatalf("Prompt was never called with a verification URL")
+	}
+}
+
+func TestDeviceFlowAuthenticateExpires(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device_authorize", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(deviceCodeResponse{
+			DeviceCode:              "dc-1",
+			VerificationURIComplete: "https://example.com/device",
+			ExpiresIn:               1,
+			Interval:                1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(SrvError{Error: "authorization_pending"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := &DeviceFlow{
+		DeviceAuthURL: srv.URL + "/device_authorize",
+		TokenURL:      srv.URL + "/token",
+		ClientID:      "client-1",
+		Prompt:        func(string) {},
+	}
+
+	start := time.Now()
+	_, err := f.Authenticate(context.Background())
+	if err == nil {
+		t.Fatalf("Authenticate() expected an error once the device code expired")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Authenticate() took too long to notice expiry: %v", time.Since(start))
+	}
+}
+
+func TestDeviceFlowAuthenticateCancelled(t *testing.T) {
+	srv := httptest.NewServer(newFakeDeviceServer(1000, 0))
+	defer srv.Close()
+
+	f := &DeviceFlow{
+		DeviceAuthURL: srv.URL + "/device_authorize",
+		TokenURL:      srv.URL + "/token",
+		ClientID:      "client-1",
+		Prompt:        func(string) {},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := f.Authenticate(ctx); err == nil {
+		t.Fatalf("Authenticate() expected an error once ctx was cancelled")
+	}
+}
+
+func TestPKCEFlowAuthenticateStateMismatch(t *testing.T) {
+	prompted := make(chan string, 1)
+	f := &PKCEFlow{
+		AuthURL:      "https://example.com/authorize",
+		TokenURL:     "https://example.com/token",
+		ClientID:     "client-1",
+		RedirectPort: 0,
+		Prompt:       func(authURL string) { prompted <- authURL },
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Authenticate(context.Background())
+		done <- err
+	}()
+
+	authURL := <-prompted
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("prompted URL did not parse: %v", err)
+	}
+	redirectURI := u.Query().Get("redirect_uri")
+
+	resp, err := http.Get(redirectURI + "?state=wrong&code=irrelevant")
+	if err != nil {
+		t.Fatalf("GET callback = %v", err)
+	}
+	resp.Body.Close()
+
+	if err := <-done; err == nil {
+		t.Fatalf("Authenticate() expected an error for a mismatched state")
+	}
+}
+
+func TestPKCEFlowAuthenticateErrorRedirect(t *testing.T) {
+	prompted := make(chan string, 1)
+	f := &PKCEFlow{
+		AuthURL:      "https://example.com/authorize",
+		TokenURL:     "https://example.com/token",
+		ClientID:     "client-1",
+		RedirectPort: 0,
+		Prompt:       func(authURL string) { prompted <- authURL },
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Authenticate(context.Background())
+		done <- err
+	}()
+
+	authURL := <-prompted
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	redirectURI := q.Get("redirect_uri")
+	state := q.Get("state")
+
+	resp, err := http.Get(fmt.Sprintf("%s?state=%s&error=access_denied&error_description=user+declined", redirectURI, state))
+	if err != nil {
+		t.Fatalf("GET callback = %v", err)
+	}
+	resp.Body.Close()
+
+	err = <-done
+	var oauthErr *OAuthError
+	if err == nil {
+		t.Fatalf("Authenticate() expected an OAuthError for an error redirect")
+	}
+	if !isOAuthError(err, "access_denied") {
+		t.Fatalf("Authenticate() = %v (%T), want an OAuthError(access_denied)", err, oauthErr)
+	}
+}
+
+func TestPKCEFlowAuthenticateSuccess(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("code") != "auth-code-1" {
+			json.NewEncoder(w).Encode(SrvError{Error: "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "access-1", RefreshToken: "refresh-1", ExpiresIn: 3600})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	prompted := make(chan string, 1)
+	f := &PKCEFlow{
+		AuthURL:      "https://example.com/authorize",
+		TokenURL:     srv.URL + "/token",
+		ClientID:     "client-1",
+		RedirectPort: 0,
+		Prompt:       func(authURL string) { prompted <- authURL },
+	}
+
+	type result struct {
+		tok *TokenResponse
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tok, err := f.Authenticate(context.Background())
+		done <- result{tok, err}
+	}()
+
+	authURL := <-prompted
+	u, _ := url.Parse(authURL)
+	redirectURI := u.Query().Get("redirect_uri")
+	state := u.Query().Get("state")
+
+	resp, err := http.Get(fmt.Sprintf("%s?state=%s&code=auth-code-1", redirectURI, state))
+	if err != nil {
+		t.Fatalf("GET callback = %v", err)
+	}
+	resp.Body.Close()
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Authenticate() = %v", r.err)
+	}
+	if r.tok.AccessToken != "access-1" {
+		t.Fatalf("AccessToken = %q, want %q", r.tok.AccessToken, "access-1")
+	}
+}
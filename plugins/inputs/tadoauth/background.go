@@ -0,0 +1,76 @@
+package tadoauth
+
+// background runs the token refresh loop: it schedules the next refresh at
+// a fraction of the token's own lifetime rather than assuming a fixed
+// expiry, and retries failed refreshes with capped exponential backoff and
+// jitter instead of giving up on the first error.
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+const (
+	// refreshFraction is how far into a token's lifetime we refresh it.
+	refreshFraction = 0.8
+	// defaultRefreshInterval is used when the server doesn't report
+	// expires_in (or reports zero) for the token currently held.
+	defaultRefreshInterval = 9 * time.Minute
+
+	initialBackoff = 5 * time.Second
+	maxBackoff     = 10 * time.Minute
+)
+
+func (s *accountSession) background(ctx context.Context, acc telegraf.Accumulator) {
+	timer := time.NewTimer(s.nextRefreshDelay())
+	defer timer.Stop()
+
+	backoff := initialBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := s.reauth(); err != nil {
+				acc.AddError(fmt.Errorf("tado token refresh failed: %w", err))
+				timer.Reset(jitter(backoff))
+				backoff = nextBackoff(backoff)
+				continue
+			}
+			backoff = initialBackoff
+			timer.Reset(s.nextRefreshDelay())
+		}
+	}
+}
+
+// nextRefreshDelay returns how long to wait before the next refresh,
+// based on the lifetime of the token currently held.
+func (s *accountSession) nextRefreshDelay() time.Duration {
+	s.mu.RLock()
+	expiresIn := s.expiresIn
+	s.mu.RUnlock()
+
+	if expiresIn <= 0 {
+		return defaultRefreshInterval
+	}
+	return time.Duration(float64(expiresIn) * refreshFraction)
+}
+
+// nextBackoff doubles d, capped at maxBackoff.
+func nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// jitter returns d randomized within +/-50% to avoid retry storms across
+// multiple plugin instances.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
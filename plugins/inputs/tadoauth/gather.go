@@ -0,0 +1,87 @@
+package tadoauth
+
+// Gather turns each account's authenticated Tado session into metrics,
+// fanning out over its configured (or discovered) homes and their zones.
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Gather implements the telegraf.Input method.
+func (c *Tadoauth) Gather(acc telegraf.Accumulator) error {
+	ctx := context.Background()
+	homes := make(map[int]*accountSession)
+
+	c.mu.RLock()
+	sessions := append([]*accountSession(nil), c.sessions...)
+	c.mu.RUnlock()
+
+	for _, session := range sessions {
+		for _, homeID := range session.gather(ctx, acc) {
+			homes[homeID] = session
+		}
+	}
+
+	c.mu.Lock()
+	c.homes = homes
+	c.mu.Unlock()
+	return nil
+}
+
+// gather fans out over s's configured (or discovered) homes, emitting
+// zone metrics tagged with the account name, and returns the home IDs it
+// gathered so the coordinator can keep its TokenFor index up to date.
+func (s *accountSession) gather(ctx context.Context, acc telegraf.Accumulator) []int {
+	homeIDs := s.cfg.HomeIDs
+	if len(homeIDs) == 0 {
+		me, err := s.client.Me(ctx)
+		if err != nil {
+			acc.AddError(err)
+			return nil
+		}
+		for _, home := range me.Homes {
+			homeIDs = append(homeIDs, home.ID)
+		}
+	}
+
+	for _, homeID := range homeIDs {
+		s.gatherHome(ctx, acc, homeID)
+	}
+	return homeIDs
+}
+
+func (s *accountSession) gatherHome(ctx context.Context, acc telegraf.Accumulator, homeID int) {
+	zones, err := s.client.Zones(ctx, homeID)
+	if err != nil {
+		acc.AddError(err)
+		return
+	}
+
+	for _, zone := range zones {
+		state, err := s.client.ZoneState(ctx, homeID, zone.ID)
+		if err != nil {
+			acc.AddError(err)
+			continue
+		}
+
+		tags := map[string]string{
+			"account": s.cfg.Name,
+			"home_id": strconv.Itoa(homeID),
+			"zone":    zone.Name,
+		}
+
+		acc.AddFields("tado_zone_temperature",
+			map[string]interface{}{"value": state.SensorDataPoints.InsideTemperature.Celsius}, tags)
+		acc.AddFields("tado_zone_humidity",
+			map[string]interface{}{"value": state.SensorDataPoints.Humidity.Percentage}, tags)
+		acc.AddFields("tado_zone_heating_power",
+			map[string]interface{}{"value": state.ActivityDataPoints.HeatingPower.Percentage}, tags)
+		acc.AddFields("tado_zone_setting", map[string]interface{}{
+			"power":       state.Setting.Power == "ON",
+			"temperature": state.Setting.Temperature.Celsius,
+		}, tags)
+	}
+}
@@ -0,0 +1,136 @@
+package tadoauth
+
+// PKCEFlow implements the OAuth2 authorization-code grant with PKCE
+// (RFC 7636): the authorization URL is handed to Prompt for the user to
+// open in a browser, and the resulting redirect is caught by a short-lived
+// loopback HTTP server.
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// PKCEFlow holds the endpoints and client identity needed for the
+// authorization-code + PKCE grant.
+type PKCEFlow struct {
+	AuthURL  string
+	TokenURL string
+	ClientID string
+	Scope    string
+
+	// RedirectPort is the loopback port the local callback server listens
+	// on. A port of 0 lets the OS choose one.
+	RedirectPort int
+
+	// Prompt is called with the authorization URL to open in a browser.
+	// Defaults to printing it to stdout.
+	Prompt func(authURL string)
+}
+
+func (f *PKCEFlow) Authenticate(ctx context.Context) (*TokenResponse, error) {
+	verifier, err := randomURLSafeString(32)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate PKCE code verifier: %w", err)
+	}
+	challenge := codeChallengeS256(verifier)
+
+	state, err := randomURLSafeString(16)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate OAuth2 state: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", f.RedirectPort))
+	if err != nil {
+		return nil, fmt.Errorf("could not start PKCE redirect listener: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", ln.Addr().(*net.TCPAddr).Port)
+
+	codeCh := make(chan string, 1)
+	errCh := make(chan error, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			errCh <- fmt.Errorf("redirect returned mismatched state")
+			return
+		}
+		if errStr := q.Get("error"); errStr != "" {
+			http.Error(w, errStr, http.StatusBadRequest)
+			errCh <- &OAuthError{Code: errStr, Description: q.Get("error_description")}
+			return
+		}
+		fmt.Fprintln(w, "Tado login complete, you may close this window.")
+		codeCh <- q.Get("code")
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	authURL, err := url.Parse(f.AuthURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization URL: %w", err)
+	}
+	q := authURL.Query()
+	q.Set("client_id", f.ClientID)
+	q.Set("response_type", "code")
+	q.Set("scope", f.Scope)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	prompt := f.Prompt
+	if prompt == nil {
+		prompt = func(u string) {
+			fmt.Printf("To authenticate with Tado, open: %s\n", u)
+		}
+	}
+	prompt(authURL.String())
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-errCh:
+		return nil, err
+	case code := <-codeCh:
+		return postForm(ctx, f.TokenURL, url.Values{
+			"client_id":     {f.ClientID},
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		})
+	}
+}
+
+func (f *PKCEFlow) Refresh(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	return postForm(ctx, f.TokenURL, url.Values{
+		"client_id":     {f.ClientID},
+		"grant_type":    {"refresh_token"},
+		"scope":         {f.Scope},
+		"refresh_token": {refreshToken},
+	})
+}
+
+func randomURLSafeString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
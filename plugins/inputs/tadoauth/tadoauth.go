@@ -4,13 +4,8 @@ package tadoauth
 // get authorization cookie and refresh if necessary
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"os"
-	"time"
+	"sync"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
@@ -22,14 +17,60 @@ type SrvError struct {
 	Description string `json:"error_description"`
 }
 
-// Tadoauth is the interface for authenticating to the tado website
+// AccountConfig is one [[inputs.tadoauth.account]] sub-table: the fields
+// that typically differ between Tado accounts managed by the same plugin
+// instance (e.g. landlord + tenant, or multiple homes). ClientID, TokenPath
+// and HomeIDs fall back to the top-level Tadoauth config when not set
+// here; Username and Password do not, and Name falls back to Username.
+type AccountConfig struct {
+	Name      string `toml:"name"`
+	Username  string `toml:"username"`
+	Password  string `toml:"password"`
+	TokenPath string `toml:"token_path"`
+	ClientID  string `toml:"client_id"`
+	// HomeIDs restricts Gather to the given homes for this account only.
+	// If empty, falls back to the coordinator's top-level home_ids.
+	HomeIDs []int `toml:"home_ids"`
+}
+
+// Tadoauth is a coordinator that authenticates one or more Tado accounts
+// and gathers their zone metrics. With no [[inputs.tadoauth.account]]
+// sub-tables it manages a single account from its own top-level fields,
+// preserving single-account configs.
 type Tadoauth struct {
 	URL          string `toml:"url"`
+	AuthFlowName string `toml:"auth_flow"`
+	ClientID     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
 	Username     string `toml:"username"`
 	Password     string `toml:"password"`
 	TokenPath    string `toml:"bearer_token"`
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+
+	// DeviceAuthURL is only required when auth_flow = "device".
+	DeviceAuthURL string `toml:"device_auth_url"`
+	// AuthURL is only required when auth_flow = "pkce".
+	AuthURL string `toml:"auth_url"`
+
+	// TokenStoreBackend selects how each account's rotating token record
+	// is persisted: "file" (default, at its token_path), "memory" (lost
+	// on restart) or "keychain" (host OS secret store).
+	TokenStoreBackend string `toml:"token_store"`
+
+	// APIURL is the base URL of the Tado REST API Gather reads metrics
+	// from.
+	APIURL string `toml:"api_url"`
+	// HomeIDs restricts Gather to the given homes. If empty, all homes
+	// each account has access to are gathered.
+	HomeIDs []int `toml:"home_ids"`
+
+	// Accounts configures the Tado accounts to manage. When it is set, it
+	// replaces the single account built from the top-level
+	// Username/Password, which are then not used.
+	Accounts []AccountConfig `toml:"account"`
+
+	mu       sync.RWMutex
+	sessions []*accountSession
+	homes    map[int]*accountSession
 }
 
 // SampleConfig telegraf.Input interface
@@ -38,134 +79,143 @@ func (c *Tadoauth) SampleConfig() string {
 // Get and refresh access tokens for authentication to the Tado website
 [[inputs.tadoauth]]
 url = "https://auth.tado.com/oauth/token"
+auth_flow = "device"
+client_id = ""
+client_secret = ""
 username = ""
 password = ""
 bearer_token = "tado.dat"
+device_auth_url = "https://auth.tado.com/oauth/device_authorize"
+auth_url = "https://auth.tado.com/oauth/authorize"
+token_store = "file"
+api_url = "https://my.tado.com"
+# home_ids = [12345]
+
+# Manage several accounts instead of the single one above; once any
+# account is configured, the top-level username/password are not used.
+# [[inputs.tadoauth.account]]
+#   name = "tenant"
+#   username = ""
+#   password = ""
+#   token_path = "tenant.dat"
+#   # home_ids overrides the top-level setting for this account only.
+#   # home_ids = [67890]
 `
 }
 
 // Init implements the telegraf Init method
 func (c *Tadoauth) Init() error {
-	err := c.auth()
-	if err != nil {
-		return err
+	accounts := c.Accounts
+	if len(accounts) == 0 {
+		accounts = []AccountConfig{{
+			Name:      "default",
+			Username:  c.Username,
+			Password:  c.Password,
+			TokenPath: c.TokenPath,
+			ClientID:  c.ClientID,
+		}}
 	}
-	err = c.store()
-	if err != nil {
-		return err
-	}
-	go c.background() // start a re-authentication loop in the background
-	return nil
-}
 
-// Description describes the the tado interface
-func (c *Tadoauth) Description() string {
-	return "Store bearer-token from Tado in file"
-}
-
-// No action for the Gather interface
-func (c *Tadoauth) Gather(acc telegraf.Accumulator) error {
-	return (nil)
-}
+	for _, account := range accounts {
+		cfg := c.resolveAccountConfig(account)
 
-func init() {
-	inputs.Add("tadoauth", func() telegraf.Input {
-		return &Tadoauth{URL: "https://auth.tado.com/oauth/token",
-			TokenPath: "/tmp/bearer.dat"}
-	})
+		session, err := newAccountSession(cfg)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", cfg.Name, err)
+		}
+		if err := session.init(); err != nil {
+			return err
+		}
+		c.sessions = append(c.sessions, session)
+	}
+	return nil
 }
 
-func (c *Tadoauth) reauth() error {
-	var srvErr SrvError
-
-	resp, err := http.PostForm(c.URL,
-		url.Values{
-			"client_id":     {"public-api-preview"},
-			"grant_type":    {"refresh_token"},
-			"scope":         {"home.user"},
-			"client_secret": {"4HJGRffVR8xb3XdEUQpjgZ1VplJi6Xgw"},
-			"refresh_token": {c.RefreshToken}})
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not connect to tado: %v\n", err)
-		return err
+// resolveAccountConfig merges account's per-account overrides onto the
+// coordinator's top-level settings.
+func (c *Tadoauth) resolveAccountConfig(account AccountConfig) accountConfig {
+	name := account.Name
+	if name == "" {
+		name = account.Username
 	}
-
-	b, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Server response error %s: %v\n", c.URL, err)
-		return err
+	clientID := account.ClientID
+	if clientID == "" {
+		clientID = c.ClientID
 	}
-
-	err = json.Unmarshal(b, &srvErr)
-	if srvErr.Error != "" {
-		fmt.Fprintf(os.Stderr, "Tado returned error: %s(%s)\n", srvErr.Error,
-			srvErr.Description)
+	tokenPath := account.TokenPath
+	if tokenPath == "" {
+		tokenPath = c.TokenPath
+	}
+	homeIDs := account.HomeIDs
+	if len(homeIDs) == 0 {
+		homeIDs = c.HomeIDs
 	}
 
-	if err := json.Unmarshal(b, c); err != nil {
-		fmt.Fprintf(os.Stderr, "Tado returned malformed response: %s\n", err)
-		return err
+	return accountConfig{
+		Name:          name,
+		URL:           c.URL,
+		AuthFlowName:  c.AuthFlowName,
+		ClientID:      clientID,
+		ClientSecret:  c.ClientSecret,
+		Username:      account.Username,
+		Password:      account.Password,
+		TokenPath:     tokenPath,
+		DeviceAuthURL: c.DeviceAuthURL,
+		AuthURL:       c.AuthURL,
+		TokenStore:    c.TokenStoreBackend,
+		APIURL:        c.APIURL,
+		HomeIDs:       homeIDs,
 	}
-	return nil
 }
 
-// authenticate with username and password. receive Access- and Refresh tokens
-func (c *Tadoauth) auth() error {
-	var srvErr SrvError
-
-	resp, err := http.PostForm(c.URL,
-		url.Values{
-			"client_id":     {"public-api-preview"},
-			"grant_type":    {"password"},
-			"scope":         {"home.user"},
-			"username":      {c.Username},
-			"password":      {c.Password},
-			"client_secret": {"4HJGRffVR8xb3XdEUQpjgZ1VplJi6Xgw"}})
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not connect to tado: %v\n", err)
-		return err
+// Start implements the telegraf ServiceInput method. The refresh loops
+// need the plugin's Accumulator to surface failures, which Init does not
+// receive, so they are launched here rather than from Init.
+func (c *Tadoauth) Start(acc telegraf.Accumulator) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, session := range c.sessions {
+		session.start(acc)
 	}
+	return nil
+}
 
-	b, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Server response error %s: %v\n", c.URL, err)
-		return err
+// Stop implements the telegraf ServiceInput method, shutting down every
+// account's refresh loop so none leak across a plugin reload.
+func (c *Tadoauth) Stop() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, session := range c.sessions {
+		session.stop()
 	}
+}
 
-	err = json.Unmarshal(b, &srvErr)
-	if srvErr.Error != "" {
-		fmt.Fprintf(os.Stderr, "Tado returned error: %s(%s)\n", srvErr.Error,
-			srvErr.Description)
-	}
+// TokenFor returns the current access token for the account that owns
+// homeID, as last observed by Gather. It is safe to call concurrently
+// with Gather.
+func (c *Tadoauth) TokenFor(homeID int) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	if err := json.Unmarshal(b, c); err != nil {
-		fmt.Fprintf(os.Stderr, "Tado returned malformed response: %s\n", err)
-		return err
+	session, ok := c.homes[homeID]
+	if !ok {
+		return "", fmt.Errorf("tadoauth: no account owns home %d", homeID)
 	}
-	return nil
+	return session.AccessToken(), nil
 }
 
-func (c *Tadoauth) background() {
-	ticker := time.NewTicker(9 * time.Minute) // tokens expire in 10min
-	for range ticker.C {
-		err := c.reauth()
-		if err != nil {
-			break
-		}
-		err = c.store()
-		if err != nil {
-			break
-		}
-	}
+// Description describes the the tado interface
+func (c *Tadoauth) Description() string {
+	return "Maintain one or more Tado OAuth2 sessions and gather zone metrics"
 }
 
-// store the access token in file, so other functions can read it
-func (c *Tadoauth) store() error {
-	dat := []byte(c.AccessToken)
-	err := os.WriteFile(c.TokenPath, dat, 0666)
-	return err
+func init() {
+	inputs.Add("tadoauth", func() telegraf.Input {
+		return &Tadoauth{
+			URL:          "https://auth.tado.com/oauth/token",
+			AuthFlowName: "password",
+			TokenPath:    "/tmp/bearer.dat",
+			APIURL:       "https://my.tado.com",
+		}
+	})
 }
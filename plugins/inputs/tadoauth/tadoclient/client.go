@@ -0,0 +1,139 @@
+// Package tadoclient is a thin, typed wrapper around the parts of the
+// Tado REST API this plugin needs to turn an authenticated session into
+// metrics: the account's homes, a home's zones, and a zone's current
+// state.
+package tadoclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// TokenSource supplies the bearer token to authenticate requests with.
+// tadoauth's per-account session satisfies this via its AccessToken
+// method so this package doesn't need to import tadoauth (which imports
+// tadoclient).
+type TokenSource interface {
+	AccessToken() string
+}
+
+// Client is a minimal Tado REST API client authenticated via a
+// TokenSource.
+type Client struct {
+	BaseURL    string
+	Tokens     TokenSource
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the given Tado API base URL (e.g.
+// "https://my.tado.com"), authenticating requests with tokens.
+func New(baseURL string, tokens TokenSource) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		Tokens:     tokens,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Home is one home in the account reported by Me.
+type Home struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Me is the response of GET /api/v2/me.
+type Me struct {
+	Name  string `json:"name"`
+	Homes []Home `json:"homes"`
+}
+
+// Me returns the authenticated user's profile, including the homes they
+// have access to.
+func (c *Client) Me(ctx context.Context) (*Me, error) {
+	var me Me
+	if err := c.get(ctx, "/api/v2/me", &me); err != nil {
+		return nil, err
+	}
+	return &me, nil
+}
+
+// Zone is one zone (room) in a home reported by Zones.
+type Zone struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Zones returns the zones configured for homeID.
+func (c *Client) Zones(ctx context.Context, homeID int) ([]Zone, error) {
+	var zones []Zone
+	path := fmt.Sprintf("/api/v2/homes/%d/zones", homeID)
+	if err := c.get(ctx, path, &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// celsius is the common {"celsius": n.n} shape the Tado API nests
+// temperature readings in.
+type celsius struct {
+	Celsius float64 `json:"celsius"`
+}
+
+// percentage is the common {"percentage": n.n} shape the Tado API nests
+// humidity and heating power readings in.
+type percentage struct {
+	Percentage float64 `json:"percentage"`
+}
+
+// ZoneState is the response of GET /api/v2/homes/{id}/zones/{zid}/state.
+type ZoneState struct {
+	Setting struct {
+		Power       string  `json:"power"`
+		Temperature celsius `json:"temperature"`
+	} `json:"setting"`
+	SensorDataPoints struct {
+		InsideTemperature celsius    `json:"insideTemperature"`
+		Humidity          percentage `json:"humidity"`
+	} `json:"sensorDataPoints"`
+	ActivityDataPoints struct {
+		HeatingPower percentage `json:"heatingPower"`
+	} `json:"activityDataPoints"`
+}
+
+// ZoneState returns the current state (measured temperature, humidity,
+// heating power and configured setting) of zoneID in homeID.
+func (c *Client) ZoneState(ctx context.Context, homeID, zoneID int) (*ZoneState, error) {
+	var state ZoneState
+	path := fmt.Sprintf("/api/v2/homes/%d/zones/%d/state", homeID, zoneID)
+	if err := c.get(ctx, path, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// get performs an authenticated GET against path and decodes the JSON
+// response body into out.
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Tokens.AccessToken())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach tado API %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tado API %s returned status %s", path, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("tado API %s returned malformed response: %w", path, err)
+	}
+	return nil
+}
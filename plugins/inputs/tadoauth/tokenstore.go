@@ -0,0 +1,132 @@
+package tadoauth
+
+// TokenStore persists the rotating refresh-token record between runs of
+// the plugin (and between successive refreshes within a run) so reuse of
+// a stale refresh token can be detected per the refresh token rotation
+// pattern of RFC 6819 §5.2.2.3.
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNoToken is returned by TokenStore.Load when no token record has been
+// persisted yet.
+var ErrNoToken = errors.New("no token record stored")
+
+// TokenRecord is the on-disk representation of a rotated token pair. Nonce
+// increases by one on every successful refresh of TokenID; a Load()
+// returning a TokenID or Nonce that differs from what the caller last saw
+// means the refresh token was already rotated elsewhere and must be
+// treated as compromised.
+type TokenRecord struct {
+	TokenID      string    `json:"token_id"`
+	Nonce        uint64    `json:"nonce"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	LastUsed     time.Time `json:"last_used"`
+}
+
+// TokenStore loads and saves the current TokenRecord.
+type TokenStore interface {
+	Load() (*TokenRecord, error)
+	Save(rec *TokenRecord) error
+}
+
+// newTokenID returns a fresh random identifier for a newly authenticated
+// token chain.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// FileTokenStore persists the token record as JSON at Path, writing via a
+// temp-file-then-rename so a reader never observes a partial write, and
+// with file mode 0600 so the refresh token isn't left world-readable.
+type FileTokenStore struct {
+	Path string
+}
+
+func (s *FileTokenStore) Load() (*TokenRecord, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read token store %s: %w", s.Path, err)
+	}
+
+	var rec TokenRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("token store %s is corrupt: %w", s.Path, err)
+	}
+	return &rec, nil
+}
+
+func (s *FileTokenStore) Save(rec *TokenRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.Path)
+	tmp, err := ioutil.TempFile(dir, ".tado-token-*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temp file in %s: %w", dir, err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the rename below succeeds
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write token store: %w", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not set token store permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write token store: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		return fmt.Errorf("could not install token store %s: %w", s.Path, err)
+	}
+	return nil
+}
+
+// MemoryTokenStore keeps the token record in process memory only, useful
+// for tests and for setups that re-authenticate on every restart.
+type MemoryTokenStore struct {
+	mu  sync.Mutex
+	rec *TokenRecord
+}
+
+func (s *MemoryTokenStore) Load() (*TokenRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec == nil {
+		return nil, ErrNoToken
+	}
+	cp := *s.rec
+	return &cp, nil
+}
+
+func (s *MemoryTokenStore) Save(rec *TokenRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *rec
+	s.rec = &cp
+	return nil
+}